@@ -0,0 +1,356 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	kg "github.com/kubearmor/KubeArmor/protobuf"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// EventInfo is the normalized shape of a single KubeArmor alert or log
+// event. WatchAlerts/WatchLogs populate one of these per message received
+// from the relay before handing it to the configured filter/pipeline/sinks.
+type EventInfo struct {
+	Timestamp     string `json:"Timestamp"`
+	ClusterName   string `json:"ClusterName,omitempty"`
+	HostName      string `json:"HostName,omitempty"`
+	NamespaceName string `json:"NamespaceName"`
+	PodName       string `json:"PodName"`
+	Labels        string `json:"Labels,omitempty"`
+	ContainerName string `json:"ContainerName,omitempty"`
+	Source        string `json:"Source,omitempty"`
+	Operation     string `json:"Operation,omitempty"`
+	Resource      string `json:"Resource,omitempty"`
+	Data          string `json:"Data,omitempty"`
+	Action        string `json:"Action,omitempty"`
+	Result        string `json:"Result,omitempty"`
+	Severity      string `json:"Severity,omitempty"`
+	Tags          string `json:"Tags,omitempty"`
+}
+
+// Limitchan is signalled once per watch loop (alerts/logs) that exits after
+// delivering Options.Limit events, so StartObserver knows when to return.
+var Limitchan chan bool
+
+// Client is a gRPC client for the KubeArmor relay's log service, plus the
+// watch loops built on top of it.
+type Client struct {
+	conn   *grpc.ClientConn
+	client kg.LogServiceClient
+
+	gRPC      string
+	msgPath   string
+	logPath   string
+	logFilter string
+	limit     uint32
+
+	// Running is cleared by StartObserver just before DestroyClient, so an
+	// in-flight watch loop knows not to redial after its stream ends.
+	Running bool
+
+	mu      sync.Mutex
+	cancels map[*cancelToken]context.CancelFunc
+}
+
+// cancelToken identifies one registerCancel call so unregisterCancel can
+// remove exactly that entry. A context.CancelFunc value itself can't be
+// used as the map key for this: each of registerCancel/unregisterCancel
+// would take its own copy of the func value in its own stack frame, so
+// &cancel in one call never equals &cancel in the other.
+type cancelToken struct{}
+
+// NewClient creates a gRPC client for the relay at gRPC and returns a Client
+// ready to watch messages/alerts/logs. The connection itself is established
+// lazily on first use. It returns nil if the gRPC client cannot be created.
+func NewClient(gRPC, msgPath, logPath, logFilter string, limit uint32) *Client {
+	conn, err := grpc.NewClient(gRPC, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to create gRPC client for %s: %s\n", gRPC, err)
+		return nil
+	}
+
+	return &Client{
+		conn:      conn,
+		client:    kg.NewLogServiceClient(conn),
+		gRPC:      gRPC,
+		msgPath:   msgPath,
+		logPath:   logPath,
+		logFilter: logFilter,
+		limit:     limit,
+		Running:   true,
+		cancels:   map[*cancelToken]context.CancelFunc{},
+	}
+}
+
+// DoHealthCheck round-trips a random nonce through the relay's HealthCheck
+// RPC to confirm the gRPC connection is actually live, not just dialed.
+func (lc *Client) DoHealthCheck() bool {
+	nonce := rand.Int31() // #nosec G404 -- liveness nonce, not security-sensitive
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := lc.client.HealthCheck(ctx, &kg.NonceMessage{Nonce: nonce})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck failed: %s\n", err)
+		return false
+	}
+	return res.Retval == nonce
+}
+
+// registerCancel tracks cancel so a later Reconnect can tear down the
+// stream it belongs to, and returns a token identifying this registration.
+// unregisterCancel(token) removes it once the stream that owns it has
+// already ended on its own.
+func (lc *Client) registerCancel(cancel context.CancelFunc) *cancelToken {
+	token := &cancelToken{}
+	lc.mu.Lock()
+	lc.cancels[token] = cancel
+	lc.mu.Unlock()
+	return token
+}
+
+func (lc *Client) unregisterCancel(token *cancelToken) {
+	lc.mu.Lock()
+	delete(lc.cancels, token)
+	lc.mu.Unlock()
+}
+
+// Reconnect cancels every open stream context, so a transient forwarder
+// failure (see pkg/tunnel's OnError) doesn't leave the watch loops stuck
+// reading from a connection that will never receive another byte.
+// WatchAlerts/WatchLogs reopen a fresh stream against the same address once
+// their Recv() unblocks with the cancellation error; WatchMessages does not
+// reopen its stream and returns.
+func (lc *Client) Reconnect() {
+	lc.mu.Lock()
+	cancels := make([]context.CancelFunc, 0, len(lc.cancels))
+	for _, cancel := range lc.cancels {
+		cancels = append(cancels, cancel)
+	}
+	lc.mu.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+// DestroyClient closes the underlying gRPC connection.
+func (lc *Client) DestroyClient() error {
+	return lc.conn.Close()
+}
+
+// WatchMessages streams KubeArmor's own operational messages (startup,
+// shutdown, errors) to msgPath (or stdout, if msgPath is "stdout").
+func (lc *Client) WatchMessages(msgPath string, jsonFormat bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	token := lc.registerCancel(cancel)
+	defer func() {
+		lc.unregisterCancel(token)
+		cancel()
+	}()
+
+	stream, err := lc.client.WatchMessages(ctx, &kg.RequestMessage{Filter: lc.logFilter})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open message stream: %s\n", err)
+		return
+	}
+
+	out := os.Stdout
+	if msgPath != "" && msgPath != "stdout" {
+		f, err := os.OpenFile(msgPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to open message log %s: %s\n", msgPath, err)
+			return
+		}
+		defer f.Close()
+		out = f
+	}
+
+	for lc.Running {
+		res, err := stream.Recv()
+		if err != nil {
+			return
+		}
+
+		if jsonFormat {
+			b, err := json.Marshal(res)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintln(out, string(b))
+		} else {
+			fmt.Fprintf(out, "%s %s %s\n", res.Timestamp, res.Source, res.Message)
+		}
+	}
+}
+
+// WatchAlerts streams policy/system alerts from the relay, running each
+// one through the configured filter and pipeline/sinks before returning
+// for the next. On a stream error it reopens a fresh stream rather than
+// returning, so a reconnect triggered by Reconnect (or any other transient
+// failure) is transparent to the caller.
+func (lc *Client) WatchAlerts(o Options) {
+	defer func() { Limitchan <- true }()
+
+	var delivered uint32
+	for lc.Running {
+		if !lc.watchAlertsOnce(o, &delivered) {
+			return
+		}
+	}
+}
+
+func (lc *Client) watchAlertsOnce(o Options, delivered *uint32) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	token := lc.registerCancel(cancel)
+	defer func() {
+		lc.unregisterCancel(token)
+		cancel()
+	}()
+
+	stream, err := lc.client.WatchAlerts(ctx, &kg.RequestMessage{Filter: o.LogFilter})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open alert stream: %s\n", err)
+		time.Sleep(time.Second)
+		return lc.Running
+	}
+
+	for lc.Running {
+		res, err := stream.Recv()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "alert stream ended, reopening: %s\n", err)
+			return lc.Running
+		}
+
+		lc.dispatch(o, alertToEvent(res))
+
+		if o.Limit != 0 {
+			*delivered++
+			if *delivered >= o.Limit {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// WatchLogs streams container/host syscall logs from the relay, mirroring
+// WatchAlerts' filter -> pipeline/sinks wiring and reconnect behaviour.
+func (lc *Client) WatchLogs(o Options) {
+	defer func() { Limitchan <- true }()
+
+	var delivered uint32
+	for lc.Running {
+		if !lc.watchLogsOnce(o, &delivered) {
+			return
+		}
+	}
+}
+
+func (lc *Client) watchLogsOnce(o Options, delivered *uint32) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	token := lc.registerCancel(cancel)
+	defer func() {
+		lc.unregisterCancel(token)
+		cancel()
+	}()
+
+	stream, err := lc.client.WatchLogs(ctx, &kg.RequestMessage{Filter: o.LogFilter})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open log stream: %s\n", err)
+		time.Sleep(time.Second)
+		return lc.Running
+	}
+
+	for lc.Running {
+		res, err := stream.Recv()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "log stream ended, reopening: %s\n", err)
+			return lc.Running
+		}
+
+		lc.dispatch(o, logToEvent(res))
+
+		if o.Limit != 0 {
+			*delivered++
+			if *delivered >= o.Limit {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// dispatch runs evt through the compiled filter (if any) and on to the
+// pipeline when one is configured, falling straight through to the sinks
+// otherwise. This is the one place WatchAlerts/WatchLogs hand events off
+// to everything buildFilterExpr/NewPipeline/buildSinks built in
+// StartObserver.
+func (lc *Client) dispatch(o Options, evt EventInfo) {
+	if o.CompiledFilter != nil {
+		matched, err := o.CompiledFilter.Match(evt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "filter evaluation failed: %s\n", err)
+			return
+		}
+		if !matched {
+			return
+		}
+	}
+
+	if o.Pipeline != nil {
+		o.Pipeline.Submit(evt)
+		return
+	}
+
+	dispatchToSinks(o.Sinks, evt)
+}
+
+func alertToEvent(a *kg.Alert) EventInfo {
+	return EventInfo{
+		Timestamp:     a.Timestamp,
+		ClusterName:   a.ClusterName,
+		HostName:      a.HostName,
+		NamespaceName: a.NamespaceName,
+		PodName:       a.PodName,
+		Labels:        a.Labels,
+		ContainerName: a.ContainerName,
+		Source:        a.Source,
+		Operation:     a.Operation,
+		Resource:      a.Resource,
+		Data:          a.Data,
+		Action:        a.Action,
+		Result:        a.Result,
+		Severity:      a.Severity,
+		Tags:          a.Tags,
+	}
+}
+
+func logToEvent(l *kg.Log) EventInfo {
+	return EventInfo{
+		Timestamp:     l.Timestamp,
+		ClusterName:   l.ClusterName,
+		HostName:      l.HostName,
+		NamespaceName: l.NamespaceName,
+		PodName:       l.PodName,
+		Labels:        l.Labels,
+		ContainerName: l.ContainerName,
+		Source:        l.Source,
+		Operation:     l.Operation,
+		Resource:      l.Resource,
+		Data:          l.Data,
+		Result:        l.Result,
+		Tags:          l.Tags,
+	}
+}
@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRegisterUnregisterCancel(t *testing.T) {
+	lc := &Client{cancels: map[*cancelToken]context.CancelFunc{}}
+
+	_, cancelA := context.WithCancel(context.Background())
+	_, cancelB := context.WithCancel(context.Background())
+
+	tokenA := lc.registerCancel(cancelA)
+	tokenB := lc.registerCancel(cancelB)
+	if len(lc.cancels) != 2 {
+		t.Fatalf("len(cancels) = %d, want 2 after registering two streams", len(lc.cancels))
+	}
+
+	lc.unregisterCancel(tokenA)
+	if len(lc.cancels) != 1 {
+		t.Fatalf("len(cancels) = %d, want 1 after unregistering one of two", len(lc.cancels))
+	}
+	if _, ok := lc.cancels[tokenB]; !ok {
+		t.Fatalf("unregisterCancel(tokenA) removed the wrong entry")
+	}
+
+	lc.unregisterCancel(tokenB)
+	if len(lc.cancels) != 0 {
+		t.Fatalf("len(cancels) = %d, want 0 after unregistering both", len(lc.cancels))
+	}
+}
+
+func TestReconnectCancelsEveryRegisteredStream(t *testing.T) {
+	lc := &Client{cancels: map[*cancelToken]context.CancelFunc{}}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	ctxB, cancelB := context.WithCancel(context.Background())
+	lc.registerCancel(cancelA)
+	lc.registerCancel(cancelB)
+
+	lc.Reconnect()
+
+	if ctxA.Err() == nil || ctxB.Err() == nil {
+		t.Fatalf("Reconnect() should cancel every registered stream context")
+	}
+}
@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+)
+
+// EventFilter evaluates a compiled CEL expression against a single event,
+// replacing the client-side regex checks that previously ran after every
+// event had already been streamed off the relay.
+type EventFilter struct {
+	prg cel.Program
+}
+
+// filterEnv declares the variables and helper functions available to a
+// --filter expression.
+func filterEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("namespace", cel.StringType),
+		cel.Variable("pod", cel.StringType),
+		cel.Variable("container", cel.StringType),
+		cel.Variable("operation", cel.StringType),
+		cel.Variable("resource", cel.StringType),
+		cel.Variable("source", cel.StringType),
+		cel.Variable("labels", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("action", cel.StringType),
+		cel.Variable("severity", cel.StringType),
+		cel.Variable("tags", cel.ListType(cel.StringType)),
+
+		cel.Function("regex",
+			cel.Overload("regex_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(celRegexMatch))),
+
+		cel.Function("cidr",
+			cel.Overload("cidr_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(celCIDRMatch))),
+
+		cel.Function("in",
+			cel.Overload("in_string_list", []*cel.Type{cel.StringType, cel.ListType(cel.StringType)}, cel.BoolType,
+				cel.BinaryBinding(celIn))),
+	)
+}
+
+func celRegexMatch(value, pattern ref.Val) ref.Val {
+	s, ok := value.Value().(string)
+	if !ok {
+		return types.NewErr("regex: value is not a string")
+	}
+	p, ok := pattern.Value().(string)
+	if !ok {
+		return types.NewErr("regex: pattern is not a string")
+	}
+	matched, err := regexp.MatchString(p, s)
+	if err != nil {
+		return types.NewErr("regex: %s", err)
+	}
+	return types.Bool(matched)
+}
+
+func celCIDRMatch(value, cidr ref.Val) ref.Val {
+	s, ok := value.Value().(string)
+	if !ok {
+		return types.NewErr("cidr: value is not a string")
+	}
+	c, ok := cidr.Value().(string)
+	if !ok {
+		return types.NewErr("cidr: cidr is not a string")
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return types.Bool(false)
+	}
+	_, network, err := net.ParseCIDR(c)
+	if err != nil {
+		return types.NewErr("cidr: %s", err)
+	}
+	return types.Bool(network.Contains(ip))
+}
+
+func celIn(value, list ref.Val) ref.Val {
+	s, ok := value.Value().(string)
+	if !ok {
+		return types.NewErr("in: value is not a string")
+	}
+	lister, ok := list.(traits.Lister)
+	if !ok {
+		return types.NewErr("in: second argument is not a list")
+	}
+	size, ok := lister.Size().(types.Int)
+	if !ok {
+		return types.NewErr("in: unable to determine list size")
+	}
+	for i := types.Int(0); i < size; i++ {
+		item := lister.Get(i)
+		if str, ok := item.Value().(string); ok && str == s {
+			return types.Bool(true)
+		}
+	}
+	return types.Bool(false)
+}
+
+// NewEventFilter compiles a CEL expression into an EventFilter.
+func NewEventFilter(expr string) (*EventFilter, error) {
+	env, err := filterEnv()
+	if err != nil {
+		return nil, fmt.Errorf("unable to build filter environment: %w", err)
+	}
+
+	ast, iss := env.Compile(expr)
+	if iss != nil && iss.Err() != nil {
+		return nil, fmt.Errorf("invalid filter expression %q: %w", expr, iss.Err())
+	}
+
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build filter program: %w", err)
+	}
+
+	return &EventFilter{prg: prg}, nil
+}
+
+// Match reports whether evt satisfies the compiled filter expression.
+func (f *EventFilter) Match(evt EventInfo) (bool, error) {
+	out, _, err := f.prg.Eval(map[string]interface{}{
+		"namespace": evt.NamespaceName,
+		"pod":       evt.PodName,
+		"container": evt.ContainerName,
+		"operation": evt.Operation,
+		"resource":  evt.Resource,
+		"source":    evt.Source,
+		"labels":    splitKV(evt.Labels),
+		"action":    evt.Action,
+		"severity":  evt.Severity,
+		"tags":      splitList(evt.Tags),
+	})
+	if err != nil {
+		return false, err
+	}
+	matched, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("filter expression did not evaluate to a bool")
+	}
+	return matched, nil
+}
+
+// splitKV parses KubeArmor's "key=value,key2=value2" label/tag encoding
+// into a map for use inside a filter expression.
+func splitKV(s string) map[string]string {
+	out := map[string]string{}
+	for _, kv := range splitList(s) {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			out[parts[0]] = parts[1]
+		} else {
+			out[parts[0]] = ""
+		}
+	}
+	return out
+}
+
+// splitList parses KubeArmor's comma-separated string fields (e.g. Tags)
+// into a list for use inside a filter expression.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		out = append(out, strings.TrimSpace(p))
+	}
+	return out
+}
+
+// legacyFilterExpr compiles the legacy regex flags (CNamespace, COperation,
+// etc.) down into an equivalent CEL expression, so old and new filtering
+// share a single evaluation path.
+func legacyFilterExpr(o Options) string {
+	var parts []string
+
+	addCaseInsensitive := func(field, value string) {
+		if value != "" {
+			parts = append(parts, fmt.Sprintf("regex(%s, %q)", field, "(?i)"+value))
+		}
+	}
+	addCaseSensitive := func(field, value string) {
+		if value != "" {
+			parts = append(parts, fmt.Sprintf("regex(%s, %q)", field, value))
+		}
+	}
+
+	addCaseInsensitive("namespace", o.Namespace)
+	addCaseInsensitive("operation", o.Operation)
+	addCaseInsensitive("container", o.ContainerName)
+	addCaseInsensitive("pod", o.PodName)
+	addCaseSensitive("source", o.Source)
+	addCaseSensitive("resource", o.Resource)
+
+	return strings.Join(parts, " && ")
+}
+
+// buildFilterExpr combines the legacy regex flags and an explicit --filter
+// expression into a single CEL expression. An empty result means "match
+// everything".
+func buildFilterExpr(o Options) string {
+	legacy := legacyFilterExpr(o)
+	switch {
+	case legacy == "":
+		return o.Filter
+	case o.Filter == "":
+		return legacy
+	default:
+		return fmt.Sprintf("(%s) && (%s)", legacy, o.Filter)
+	}
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import "testing"
+
+func TestLegacyFilterExpr(t *testing.T) {
+	o := Options{
+		Namespace: "prod",
+		Operation: "File",
+		Resource:  "/etc/passwd",
+	}
+
+	got := legacyFilterExpr(o)
+	want := `regex(namespace, "(?i)prod") && regex(operation, "(?i)File") && regex(resource, "/etc/passwd")`
+	if got != want {
+		t.Errorf("legacyFilterExpr() = %q, want %q", got, want)
+	}
+
+	if legacyFilterExpr(Options{}) != "" {
+		t.Errorf("legacyFilterExpr(Options{}) should be empty when no legacy flags are set")
+	}
+}
+
+func TestBuildFilterExpr(t *testing.T) {
+	cases := []struct {
+		name string
+		o    Options
+		want string
+	}{
+		{"empty", Options{}, ""},
+		{"explicit only", Options{Filter: `action == "Block"`}, `action == "Block"`},
+		{
+			"legacy only",
+			Options{Namespace: "prod"},
+			`regex(namespace, "(?i)prod")`,
+		},
+		{
+			"legacy and explicit",
+			Options{Namespace: "prod", Filter: `action == "Block"`},
+			`(regex(namespace, "(?i)prod")) && (action == "Block")`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := buildFilterExpr(c.o); got != c.want {
+				t.Errorf("buildFilterExpr() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestEventFilterMatch(t *testing.T) {
+	filter, err := NewEventFilter(`namespace == "prod" && regex(resource, "^/etc/")`)
+	if err != nil {
+		t.Fatalf("NewEventFilter() error: %s", err)
+	}
+
+	matched, err := filter.Match(EventInfo{NamespaceName: "prod", Resource: "/etc/shadow"})
+	if err != nil {
+		t.Fatalf("Match() error: %s", err)
+	}
+	if !matched {
+		t.Errorf("expected event in prod touching /etc/shadow to match")
+	}
+
+	matched, err = filter.Match(EventInfo{NamespaceName: "staging", Resource: "/etc/shadow"})
+	if err != nil {
+		t.Fatalf("Match() error: %s", err)
+	}
+	if matched {
+		t.Errorf("expected event outside prod to not match")
+	}
+}
+
+func TestSplitKVAndSplitList(t *testing.T) {
+	gotKV := splitKV("app=nginx,tier=frontend")
+	wantKV := map[string]string{"app": "nginx", "tier": "frontend"}
+	for k, v := range wantKV {
+		if gotKV[k] != v {
+			t.Errorf("splitKV()[%q] = %q, want %q", k, gotKV[k], v)
+		}
+	}
+
+	gotList := splitList("a, b,c")
+	wantList := []string{"a", "b", "c"}
+	if len(gotList) != len(wantList) {
+		t.Fatalf("splitList() = %v, want %v", gotList, wantList)
+	}
+	for i := range wantList {
+		if gotList[i] != wantList[i] {
+			t.Errorf("splitList()[%d] = %q, want %q", i, gotList[i], wantList[i])
+		}
+	}
+
+	if splitList("") != nil {
+		t.Errorf("splitList(\"\") should return nil")
+	}
+}
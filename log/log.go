@@ -11,12 +11,11 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
-	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/kubearmor/kubearmor-client/k8s"
-	"github.com/kubearmor/kubearmor-client/utils"
+	"github.com/kubearmor/kubearmor-client/pkg/tunnel"
 )
 
 type regexType *regexp.Regexp
@@ -49,14 +48,61 @@ type Options struct {
 	Limit         uint32
 	Selector      []string
 	EventChan     chan EventInfo // channel to send events on
+
+	// Endpoint, if set, is dialed directly instead of auto-detecting a
+	// tunnel backend (see pkg/tunnel).
+	Endpoint string
+	// KubeconfigContext selects a non-default kubeconfig context when
+	// locating the relay pod/service.
+	KubeconfigContext string
+
+	// Sink configuration: in addition to the stdout/file output above,
+	// events can be streamed to Elasticsearch/OpenSearch and/or Loki.
+	ESUrl             string
+	ESIndex           string
+	ESUsername        string
+	ESPassword        string
+	ESTLSSkipVerify   bool
+	LokiURL           string
+	SinkBatchSize     int
+	SinkFlushInterval time.Duration
+
+	// OTLP exporter configuration for forwarding telemetry to an
+	// OpenTelemetry Collector or compatible backend.
+	OTLPEndpoint string
+	OTLPHeaders  map[string]string
+	OTLPInsecure bool
+	OTLPProtocol string // "grpc" (default) or "http"
+
+	Sinks []Sink // sinks built from the fields above, fanned out to by WatchAlerts/WatchLogs
+
+	// Filter is a CEL expression evaluated against every event, e.g.
+	// `namespace == "prod" && operation == "File" && resource.startsWith("/etc/")`.
+	// The legacy Namespace/Operation/... regex flags above are compiled
+	// down into an equivalent expression and ANDed with this one.
+	Filter         string
+	CompiledFilter *EventFilter // built from Filter (and the legacy flags) by StartObserver
+
+	// Pipeline configuration: rate limiting, dedup, and buffering applied
+	// to events before they reach the sinks above.
+	MaxEventsPerSec float64
+	DedupWindow     time.Duration
+	DedupCacheSize  int
+	BufferSize      int
+	MetricsAddr     string
+	Pipeline        *Pipeline // built from the fields above by StartObserver
 }
 
 // StopChan Channel
 var StopChan chan struct{}
 var sigChan chan os.Signal
 var unblockSignal = false
-var matchLabels = map[string]string{"kubearmor-app": "kubearmor-relay"}
-var port int64 = 32767
+
+// defaultMatchLabels/defaultPort are the relay pod/service values used to
+// build the tunnel. Options has no fields that override them.
+var defaultMatchLabels = map[string]string{"kubearmor-app": "kubearmor-relay"}
+
+const defaultPort int64 = 32767
 
 // GetOSSigChannel Function
 func GetOSSigChannel() chan os.Signal {
@@ -117,17 +163,32 @@ func closeStopChan() {
 // StartObserver Function
 func StartObserver(c *k8s.Client, o Options) error {
 	gRPC := ""
+	var tun *tunnel.Tunnel
 
 	if o.GRPC != "" {
 		gRPC = o.GRPC
 	} else if val, ok := os.LookupEnv("KUBEARMOR_SERVICE"); ok {
 		gRPC = val
 	} else {
-		pf, err := utils.InitiatePortForward(c, port, port, matchLabels)
+		var err error
+		tun, err = tunnel.New(c, tunnel.Options{
+			Endpoint:          o.Endpoint,
+			MatchLabels:       defaultMatchLabels,
+			ServiceName:       "kubearmor-relay",
+			ServicePort:       defaultPort,
+			LocalPort:         defaultPort,
+			RemotePort:        defaultPort,
+			KubeconfigContext: o.KubeconfigContext,
+		})
 		if err != nil {
 			return err
 		}
-		gRPC = "localhost:" + strconv.FormatInt(pf.LocalPort, 10)
+		gRPC = tun.Address
+		defer func() {
+			if err := tun.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "error closing tunnel: %s\n", err)
+			}
+		}()
 	}
 
 	if o.MsgPath == "none" && o.LogPath == "none" {
@@ -148,6 +209,18 @@ func StartObserver(c *k8s.Client, o Options) error {
 
 	fmt.Fprintf(os.Stderr, "Created a gRPC client (%s)\n", gRPC)
 
+	if tun != nil {
+		// A transient forwarder failure (pod rescheduled, SPDY stream
+		// dropped) must not leave WatchAlerts/WatchLogs blocked forever
+		// reading from a dead gRPC connection. The callback runs as soon as
+		// the failure is seen; the alert/log loops then keep reopening their
+		// streams until the port-forward is back.
+		tun.OnError(func(err error) {
+			fmt.Fprintf(os.Stderr, "tunnel error, reconnecting gRPC streams to %s: %s\n", gRPC, err)
+			logClient.Reconnect()
+		})
+	}
+
 	// do healthcheck
 	if ok := logClient.DoHealthCheck(); !ok {
 		return errors.New("failed to check the liveness of the gRPC server")
@@ -166,6 +239,34 @@ func StartObserver(c *k8s.Client, o Options) error {
 		return err
 	}
 
+	sinks, err := buildSinks(o)
+	if err != nil {
+		return fmt.Errorf("unable to set up log sinks: %w", err)
+	}
+	o.Sinks = sinks
+
+	if expr := buildFilterExpr(o); expr != "" {
+		filter, err := NewEventFilter(expr)
+		if err != nil {
+			return fmt.Errorf("unable to compile log filter: %w", err)
+		}
+		o.CompiledFilter = filter
+	}
+
+	if o.MaxEventsPerSec > 0 || o.DedupWindow > 0 || o.MetricsAddr != "" {
+		pipeline, err := NewPipeline(PipelineConfig{
+			MaxEventsPerSec: o.MaxEventsPerSec,
+			DedupWindow:     o.DedupWindow,
+			DedupCacheSize:  o.DedupCacheSize,
+			BufferSize:      o.BufferSize,
+			MetricsAddr:     o.MetricsAddr,
+		}, o.Sinks)
+		if err != nil {
+			return fmt.Errorf("unable to set up event pipeline: %w", err)
+		}
+		o.Pipeline = pipeline
+	}
+
 	Limitchan = make(chan bool, 2)
 	if o.LogPath != "none" {
 		if o.LogFilter == "all" || o.LogFilter == "policy" {
@@ -202,6 +303,14 @@ func StartObserver(c *k8s.Client, o Options) error {
 		}
 	}
 	fmt.Fprintln(os.Stderr, "releasing grpc client")
+	if o.Pipeline != nil {
+		if err := o.Pipeline.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "error closing event pipeline: %s\n", err)
+		}
+	} else {
+		flushSinks(o.Sinks)
+		closeSinks(o.Sinks)
+	}
 	closeStopChan()
 
 	logClient.Running = false
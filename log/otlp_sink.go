@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// OTLPConfig configures the OTLP logs sink
+type OTLPConfig struct {
+	Endpoint      string
+	Headers       map[string]string
+	Insecure      bool
+	Protocol      string // "grpc" (default) or "http"
+	QueueSize     int
+	ExportTimeout time.Duration
+}
+
+// OTLPSink forwards events to any OpenTelemetry Collector or
+// OTLP-compatible backend (Jaeger, Tempo, Datadog, ...) as log records.
+type OTLPSink struct {
+	provider *sdklog.LoggerProvider
+	logger   otellog.Logger
+}
+
+// NewOTLPSink creates an OTLPSink from cfg
+func NewOTLPSink(cfg OTLPConfig) (*OTLPSink, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("otlp sink: endpoint is required")
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 2048
+	}
+	if cfg.ExportTimeout <= 0 {
+		cfg.ExportTimeout = 30 * time.Second
+	}
+
+	ctx := context.Background()
+
+	exporter, err := newOTLPExporter(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("otlp sink: unable to create exporter: %w", err)
+	}
+
+	res, err := resource.New(ctx, resource.WithAttributes(
+		semconv.ServiceNameKey.String("karmor"),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("otlp sink: unable to build resource: %w", err)
+	}
+
+	processor := sdklog.NewBatchProcessor(exporter,
+		sdklog.WithMaxQueueSize(cfg.QueueSize),
+		sdklog.WithExportTimeout(cfg.ExportTimeout),
+	)
+
+	provider := sdklog.NewLoggerProvider(
+		sdklog.WithProcessor(processor),
+		sdklog.WithResource(res),
+	)
+
+	return &OTLPSink{
+		provider: provider,
+		logger:   provider.Logger("github.com/kubearmor/kubearmor-client/log"),
+	}, nil
+}
+
+func newOTLPExporter(ctx context.Context, cfg OTLPConfig) (sdklog.Exporter, error) {
+	if cfg.Protocol == "http" {
+		opts := []otlploghttp.Option{otlploghttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlploghttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlploghttp.WithHeaders(cfg.Headers))
+		}
+		return otlploghttp.New(ctx, opts...)
+	}
+
+	opts := []otlploggrpc.Option{otlploggrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlploggrpc.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlploggrpc.WithHeaders(cfg.Headers))
+	}
+	return otlploggrpc.New(ctx, opts...)
+}
+
+// Write implements Sink
+func (s *OTLPSink) Write(evt EventInfo) error {
+	var record otellog.Record
+	record.SetTimestamp(time.Now())
+	record.SetObservedTimestamp(time.Now())
+	record.SetSeverity(otlpSeverityFor(evt))
+	record.SetBody(otellog.StringValue(strings.TrimSpace(evt.Operation + " " + evt.Resource)))
+	record.AddAttributes(
+		otellog.String("k8s.namespace.name", evt.NamespaceName),
+		otellog.String("k8s.pod.name", evt.PodName),
+		otellog.String("k8s.container.name", evt.ContainerName),
+		otellog.String("kubearmor.operation", evt.Operation),
+		otellog.String("kubearmor.resource", evt.Resource),
+		otellog.String("kubearmor.action", evt.Action),
+		otellog.String("kubearmor.result", evt.Result),
+	)
+
+	s.logger.Emit(context.Background(), record)
+	return nil
+}
+
+// Flush implements Sink
+func (s *OTLPSink) Flush() error {
+	return s.provider.ForceFlush(context.Background())
+}
+
+// Close shuts down the logger provider and its batch processor goroutine.
+func (s *OTLPSink) Close() error {
+	return s.provider.Shutdown(context.Background())
+}
+
+// otlpSeverityFor derives an OpenTelemetry log severity from an event's
+// Action (e.g. "Block", "Audit") and Result (e.g. "Passed", "Permission
+// denied"), since KubeArmor events don't carry a severity of their own.
+func otlpSeverityFor(evt EventInfo) otellog.Severity {
+	switch strings.ToLower(evt.Action) {
+	case "block":
+		return otellog.SeverityError
+	case "audit":
+		return otellog.SeverityWarn
+	}
+	if evt.Result != "" && evt.Result != "Passed" {
+		return otellog.SeverityWarn
+	}
+	return otellog.SeverityInfo
+}
@@ -0,0 +1,267 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/time/rate"
+)
+
+// PipelineConfig configures the rate-limit/dedup/buffering stage that sits
+// between the gRPC stream and the configured sinks.
+type PipelineConfig struct {
+	// MaxEventsPerSec caps how many events per second are forwarded to the
+	// sinks. Zero disables rate limiting.
+	MaxEventsPerSec float64
+	// DedupWindow suppresses repeats of the same namespace+pod+operation+
+	// resource+action seen within this window. Zero disables dedup.
+	DedupWindow time.Duration
+	// DedupCacheSize bounds the LRU used to track recently seen events.
+	DedupCacheSize int
+	// BufferSize bounds the channel between the gRPC stream and the
+	// pipeline's forwarding goroutine.
+	BufferSize int
+	// MetricsAddr, if set, serves Prometheus metrics on this address
+	// (e.g. ":9090") at /metrics.
+	MetricsAddr string
+}
+
+// Pipeline rate-limits, deduplicates, and buffers events before fanning
+// them out to the configured sinks, recording backpressure metrics along
+// the way.
+type Pipeline struct {
+	cfg     PipelineConfig
+	sinks   []Sink
+	metrics *Metrics
+
+	limiter *rate.Limiter
+	dedup   *lru.Cache[string, time.Time]
+
+	buf  chan EventInfo
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewPipeline builds a Pipeline that forwards surviving events to sinks.
+func NewPipeline(cfg PipelineConfig, sinks []Sink) (*Pipeline, error) {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 1000
+	}
+	if cfg.DedupCacheSize <= 0 {
+		cfg.DedupCacheSize = 10000
+	}
+
+	metrics := NewMetrics(sinks)
+
+	p := &Pipeline{
+		cfg:     cfg,
+		sinks:   sinks,
+		metrics: metrics,
+		buf:     make(chan EventInfo, cfg.BufferSize),
+		stop:    make(chan struct{}),
+	}
+
+	if cfg.MaxEventsPerSec > 0 {
+		burst := int(cfg.MaxEventsPerSec)
+		if burst < 1 {
+			burst = 1
+		}
+		p.limiter = rate.NewLimiter(rate.Limit(cfg.MaxEventsPerSec), burst)
+	}
+
+	if cfg.DedupWindow > 0 {
+		cache, err := lru.New[string, time.Time](cfg.DedupCacheSize)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create dedup cache: %w", err)
+		}
+		p.dedup = cache
+	}
+
+	if cfg.MetricsAddr != "" {
+		if err := metrics.Serve(cfg.MetricsAddr); err != nil {
+			return nil, fmt.Errorf("unable to start metrics server: %w", err)
+		}
+	}
+
+	p.wg.Add(1)
+	go p.run()
+
+	return p, nil
+}
+
+// Submit offers evt to the pipeline. If the internal buffer is full, the
+// event is dropped and accounted for rather than blocking the caller.
+func (p *Pipeline) Submit(evt EventInfo) {
+	p.metrics.eventsReceived.Inc()
+
+	select {
+	case p.buf <- evt:
+	default:
+		p.metrics.eventsDroppedBuffer.Inc()
+	}
+}
+
+// Close stops the pipeline's forwarding goroutine, flushes every sink, and
+// stops any background goroutines (e.g. periodic flush tickers) the sinks
+// themselves own.
+func (p *Pipeline) Close() error {
+	close(p.stop)
+	p.wg.Wait()
+	flushSinks(p.sinks)
+	closeSinks(p.sinks)
+	return nil
+}
+
+func (p *Pipeline) run() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case evt := <-p.buf:
+			p.process(evt)
+		}
+	}
+}
+
+func (p *Pipeline) process(evt EventInfo) {
+	if p.limiter != nil && !p.limiter.Allow() {
+		p.metrics.eventsDroppedRateLimit.Inc()
+		return
+	}
+
+	if p.dedup != nil {
+		key := dedupKey(evt)
+		if last, ok := p.dedup.Get(key); ok && time.Since(last) < p.cfg.DedupWindow {
+			p.metrics.eventsDroppedDedup.Inc()
+			return
+		}
+		p.dedup.Add(key, time.Now())
+	}
+
+	p.metrics.eventsForwarded.Inc()
+	dispatchToSinksWithMetrics(p.sinks, evt, p.metrics)
+}
+
+// dedupKey hashes the fields that identify "the same event" for the
+// purposes of suppressing near-duplicate bursts.
+func dedupKey(evt EventInfo) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%s|%s|%s", evt.NamespaceName, evt.PodName, evt.Operation, evt.Resource, evt.Action)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// dispatchToSinksWithMetrics fans evt out to every sink concurrently,
+// recording a sink_errors_total increment per failing sink.
+func dispatchToSinksWithMetrics(sinks []Sink, evt EventInfo, metrics *Metrics) {
+	var wg sync.WaitGroup
+	for _, sink := range sinks {
+		sink := sink
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sink.Write(evt); err != nil {
+				metrics.sinkErrors.WithLabelValues(sinkName(sink)).Inc()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func sinkName(s Sink) string {
+	switch s.(type) {
+	case *StdoutSink:
+		return "stdout"
+	case *FileSink:
+		return "file"
+	case *ElasticsearchSink:
+		return "elasticsearch"
+	case *LokiSink:
+		return "loki"
+	case *OTLPSink:
+		return "otlp"
+	default:
+		return "unknown"
+	}
+}
+
+// Metrics holds the Prometheus counters exposed on --metrics-addr.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	eventsReceived         prometheus.Counter
+	eventsForwarded        prometheus.Counter
+	eventsDroppedRateLimit prometheus.Counter
+	eventsDroppedDedup     prometheus.Counter
+	eventsDroppedBuffer    prometheus.Counter
+	sinkErrors             *prometheus.CounterVec
+}
+
+// NewMetrics registers the pipeline's counters on a fresh registry.
+func NewMetrics(sinks []Sink) *Metrics {
+	reg := prometheus.NewRegistry()
+
+	m := &Metrics{
+		registry: reg,
+		eventsReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "events_received",
+			Help: "Total events submitted to the pipeline after filtering.",
+		}),
+		eventsForwarded: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "events_forwarded",
+			Help: "Total events that passed rate limiting and dedup and were handed to the sinks.",
+		}),
+		eventsDroppedRateLimit: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "events_dropped_ratelimit",
+			Help: "Total events dropped by the token-bucket rate limiter.",
+		}),
+		eventsDroppedDedup: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "events_dropped_dedup",
+			Help: "Total events dropped as duplicates within the dedup window.",
+		}),
+		eventsDroppedBuffer: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "events_dropped_buffer",
+			Help: "Total events dropped because the pipeline buffer was full.",
+		}),
+		sinkErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sink_errors_total",
+			Help: "Total write errors per sink.",
+		}, []string{"sink"}),
+	}
+
+	reg.MustRegister(m.eventsReceived, m.eventsForwarded, m.eventsDroppedRateLimit,
+		m.eventsDroppedDedup, m.eventsDroppedBuffer, m.sinkErrors)
+
+	// Pre-create a zero-valued series per configured sink so dashboards
+	// don't have to wait for a first error to show the label.
+	for _, s := range sinks {
+		m.sinkErrors.WithLabelValues(sinkName(s))
+	}
+
+	return m
+}
+
+// Serve starts an HTTP server exposing the registry at /metrics.
+func (m *Metrics) Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintf(os.Stderr, "metrics server stopped: %s\n", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import "testing"
+
+func TestDedupKey(t *testing.T) {
+	a := EventInfo{NamespaceName: "prod", PodName: "nginx-1", Operation: "File", Resource: "/etc/passwd", Action: "Block"}
+	b := a
+	c := a
+	c.PodName = "nginx-2"
+
+	if dedupKey(a) != dedupKey(b) {
+		t.Errorf("dedupKey should be identical for identical events")
+	}
+	if dedupKey(a) == dedupKey(c) {
+		t.Errorf("dedupKey should differ when PodName differs")
+	}
+}
+
+func TestSinkName(t *testing.T) {
+	cases := []struct {
+		sink Sink
+		want string
+	}{
+		{NewStdoutSink(false), "stdout"},
+		{&FileSink{}, "file"},
+		{&ElasticsearchSink{}, "elasticsearch"},
+		{&LokiSink{}, "loki"},
+		{&OTLPSink{}, "otlp"},
+	}
+
+	for _, c := range cases {
+		if got := sinkName(c.sink); got != c.want {
+			t.Errorf("sinkName(%T) = %q, want %q", c.sink, got, c.want)
+		}
+	}
+}
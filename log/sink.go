@@ -0,0 +1,531 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// Sink is implemented by any destination that KubeArmor telemetry can be
+// streamed to. Write is called once per event and must be safe to call
+// concurrently; Flush is called on shutdown (and, for batching sinks,
+// periodically) to force delivery of any buffered events.
+type Sink interface {
+	Write(evt EventInfo) error
+	Flush() error
+}
+
+// formatEvent renders evt the same way across sinks that honour o.JSON:
+// one JSON object per line when jsonFormat is set, otherwise a compact
+// human-readable line matching the style of the existing WatchMessages
+// text output.
+func formatEvent(evt EventInfo, jsonFormat bool) ([]byte, error) {
+	if jsonFormat {
+		return json.Marshal(evt)
+	}
+	line := fmt.Sprintf("%s %s/%s (%s) %s %s %s",
+		evt.Timestamp, evt.NamespaceName, evt.PodName, evt.ContainerName,
+		evt.Operation, evt.Resource, evt.Result)
+	return []byte(line), nil
+}
+
+// StdoutSink writes events to stdout, respecting the --json flag the same
+// way the existing message/alert console output does.
+type StdoutSink struct {
+	json bool
+}
+
+// NewStdoutSink creates a Sink that prints events to stdout
+func NewStdoutSink(jsonFormat bool) *StdoutSink {
+	return &StdoutSink{json: jsonFormat}
+}
+
+// Write implements Sink
+func (s *StdoutSink) Write(evt EventInfo) error {
+	b, err := formatEvent(evt, s.json)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(os.Stdout, string(b))
+	return err
+}
+
+// Flush implements Sink
+func (s *StdoutSink) Flush() error {
+	return nil
+}
+
+// FileSink appends events, one line per event, to a file on disk.
+type FileSink struct {
+	json bool
+	mu   sync.Mutex
+	f    *os.File
+}
+
+// NewFileSink creates a Sink that appends events to the file at path
+func NewFileSink(path string, jsonFormat bool) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open sink file %s: %w", path, err)
+	}
+	return &FileSink{json: jsonFormat, f: f}, nil
+}
+
+// Write implements Sink
+func (s *FileSink) Write(evt EventInfo) error {
+	b, err := formatEvent(evt, s.json)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(b, '\n'))
+	return err
+}
+
+// Flush implements Sink
+func (s *FileSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Sync()
+}
+
+// ElasticsearchConfig configures the Elasticsearch/OpenSearch bulk sink
+type ElasticsearchConfig struct {
+	URL           string
+	Index         string
+	Username      string
+	Password      string
+	TLSSkipVerify bool
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// ElasticsearchSink batches events and ships them to Elasticsearch/OpenSearch
+// using the official bulk API, retrying failed requests with backoff.
+type ElasticsearchSink struct {
+	cfg    ElasticsearchConfig
+	client *elasticsearch.Client
+
+	mu    sync.Mutex
+	batch []EventInfo
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewElasticsearchSink creates an ElasticsearchSink from cfg
+func NewElasticsearchSink(cfg ElasticsearchConfig) (*ElasticsearchSink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("elasticsearch sink: URL is required")
+	}
+	if cfg.Index == "" {
+		cfg.Index = "kubearmor-alerts"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+
+	esClient, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: []string{cfg.URL},
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.TLSSkipVerify}, // #nosec G402
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create elasticsearch client: %w", err)
+	}
+
+	s := &ElasticsearchSink{cfg: cfg, client: esClient, stop: make(chan struct{})}
+	go s.periodicFlush()
+	return s, nil
+}
+
+func (s *ElasticsearchSink) periodicFlush() {
+	t := time.NewTicker(s.cfg.FlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := s.Flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "elasticsearch sink: periodic flush failed: %s\n", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic flush goroutine. A final Flush should be called
+// separately to deliver any buffered events.
+func (s *ElasticsearchSink) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return nil
+}
+
+// Write implements Sink
+func (s *ElasticsearchSink) Write(evt EventInfo) error {
+	s.mu.Lock()
+	s.batch = append(s.batch, evt)
+	full := len(s.batch) >= s.cfg.BatchSize
+	s.mu.Unlock()
+
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush implements Sink
+func (s *ElasticsearchSink) Flush() error {
+	s.mu.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.bulkIndexWithRetry(batch)
+}
+
+func (s *ElasticsearchSink) bulkIndexWithRetry(batch []EventInfo) error {
+	var body bytes.Buffer
+	for _, evt := range batch {
+		meta, err := json.Marshal(map[string]interface{}{
+			"index": map[string]string{"_index": s.cfg.Index},
+		})
+		if err != nil {
+			return err
+		}
+		doc, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		body.Write(meta)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+
+	backoff := 200 * time.Millisecond
+	const maxAttempts = 5
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		req := esapi.BulkRequest{Body: bytes.NewReader(body.Bytes())}
+		res, err := req.Do(context.Background(), s.client)
+		if err == nil {
+			func() {
+				defer res.Body.Close()
+				if !res.IsError() {
+					lastErr = nil
+				} else {
+					lastErr = fmt.Errorf("elasticsearch bulk index returned status %s", res.Status())
+				}
+			}()
+			if lastErr == nil {
+				return nil
+			}
+		} else {
+			lastErr = err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return fmt.Errorf("elasticsearch sink: giving up after %d attempts: %w", maxAttempts, lastErr)
+}
+
+// LokiConfig configures the Loki push sink
+type LokiConfig struct {
+	URL           string
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+// LokiSink batches events and pushes them to Loki's HTTP push API, with
+// stream labels derived from the event's namespace/podname/container.
+type LokiSink struct {
+	cfg    LokiConfig
+	client *http.Client
+
+	mu    sync.Mutex
+	batch []EventInfo
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewLokiSink creates a LokiSink from cfg
+func NewLokiSink(cfg LokiConfig) (*LokiSink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("loki sink: URL is required")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+
+	s := &LokiSink{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}, stop: make(chan struct{})}
+	go s.periodicFlush()
+	return s, nil
+}
+
+func (s *LokiSink) periodicFlush() {
+	t := time.NewTicker(s.cfg.FlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := s.Flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "loki sink: periodic flush failed: %s\n", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic flush goroutine. A final Flush should be called
+// separately to deliver any buffered events.
+func (s *LokiSink) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return nil
+}
+
+// Write implements Sink
+func (s *LokiSink) Write(evt EventInfo) error {
+	s.mu.Lock()
+	s.batch = append(s.batch, evt)
+	full := len(s.batch) >= s.cfg.BatchSize
+	s.mu.Unlock()
+
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush implements Sink
+func (s *LokiSink) Flush() error {
+	s.mu.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	streams := make(map[string]*lokiStream)
+	for _, evt := range batch {
+		labels := lokiLabelsFor(evt)
+		key := labelKey(labels)
+		st, ok := streams[key]
+		if !ok {
+			st = &lokiStream{Stream: labels}
+			streams[key] = st
+		}
+		line, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		st.Values = append(st.Values, [2]string{
+			fmt.Sprintf("%d", time.Now().UnixNano()),
+			string(line),
+		})
+	}
+
+	req := lokiPushRequest{}
+	for _, st := range streams {
+		req.Streams = append(req.Streams, *st)
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	var gzBuf bytes.Buffer
+	gw := gzip.NewWriter(&gzBuf)
+	if _, err := gw.Write(payload); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, strings.TrimRight(s.cfg.URL, "/")+"/loki/api/v1/push", &gzBuf)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Content-Encoding", "gzip")
+
+	res, err := s.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("loki sink: push failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("loki sink: push returned status %s", res.Status)
+	}
+	return nil
+}
+
+func lokiLabelsFor(evt EventInfo) map[string]string {
+	return map[string]string{
+		"namespace": evt.NamespaceName,
+		"pod":       evt.PodName,
+		"container": evt.ContainerName,
+	}
+}
+
+func labelKey(labels map[string]string) string {
+	return labels["namespace"] + "/" + labels["pod"] + "/" + labels["container"]
+}
+
+// dispatchToSinks fans evt out to every configured sink concurrently. A
+// failing sink only logs an error; the other sinks still receive evt. It
+// returns once every sink's Write has returned, so a slow sink delays the
+// caller's next event for all sinks.
+func dispatchToSinks(sinks []Sink, evt EventInfo) {
+	var wg sync.WaitGroup
+	for _, sink := range sinks {
+		sink := sink
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sink.Write(evt); err != nil {
+				fmt.Fprintf(os.Stderr, "sink write failed: %s\n", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// flushSinks flushes every configured sink, logging (but not failing on)
+// individual flush errors.
+func flushSinks(sinks []Sink) {
+	for _, sink := range sinks {
+		if err := sink.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "sink flush failed: %s\n", err)
+		}
+	}
+}
+
+// sinkCloser is implemented by sinks that own a background goroutine (e.g.
+// a periodic flush ticker) that must be stopped on shutdown.
+type sinkCloser interface {
+	Close() error
+}
+
+// closeSinks stops any background goroutines owned by the configured
+// sinks. It should be called once, after a final flushSinks.
+func closeSinks(sinks []Sink) {
+	for _, sink := range sinks {
+		if c, ok := sink.(sinkCloser); ok {
+			if err := c.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "sink close failed: %s\n", err)
+			}
+		}
+	}
+}
+
+// buildSinks constructs the set of sinks requested via Options. At most one
+// of the stdout/file sinks is added for o.LogPath, mirroring the existing
+// --log-path stdout|none|<file> convention (so events aren't printed
+// twice); Elasticsearch/Loki/OTLP sinks are added in addition to that when
+// their respective flags are set.
+func buildSinks(o Options) ([]Sink, error) {
+	var sinks []Sink
+
+	switch o.LogPath {
+	case "", "stdout":
+		sinks = append(sinks, NewStdoutSink(o.JSON))
+	case "none":
+		// no stdout/file sink
+	default:
+		fileSink, err := NewFileSink(o.LogPath, o.JSON)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, fileSink)
+	}
+
+	if o.ESUrl != "" {
+		esSink, err := NewElasticsearchSink(ElasticsearchConfig{
+			URL:           o.ESUrl,
+			Index:         o.ESIndex,
+			Username:      o.ESUsername,
+			Password:      o.ESPassword,
+			TLSSkipVerify: o.ESTLSSkipVerify,
+			BatchSize:     o.SinkBatchSize,
+			FlushInterval: o.SinkFlushInterval,
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, esSink)
+	}
+
+	if o.LokiURL != "" {
+		lokiSink, err := NewLokiSink(LokiConfig{
+			URL:           o.LokiURL,
+			BatchSize:     o.SinkBatchSize,
+			FlushInterval: o.SinkFlushInterval,
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, lokiSink)
+	}
+
+	if o.OTLPEndpoint != "" {
+		otlpSink, err := NewOTLPSink(OTLPConfig{
+			Endpoint:      o.OTLPEndpoint,
+			Headers:       o.OTLPHeaders,
+			Insecure:      o.OTLPInsecure,
+			Protocol:      o.OTLPProtocol,
+			QueueSize:     o.SinkBatchSize,
+			ExportTimeout: o.SinkFlushInterval,
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, otlpSink)
+	}
+
+	return sinks, nil
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+package log
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLokiLabelsFor(t *testing.T) {
+	evt := EventInfo{NamespaceName: "prod", PodName: "nginx-1", ContainerName: "nginx"}
+	labels := lokiLabelsFor(evt)
+
+	want := map[string]string{"namespace": "prod", "pod": "nginx-1", "container": "nginx"}
+	for k, v := range want {
+		if labels[k] != v {
+			t.Errorf("lokiLabelsFor()[%q] = %q, want %q", k, labels[k], v)
+		}
+	}
+}
+
+func TestLabelKey(t *testing.T) {
+	a := labelKey(map[string]string{"namespace": "prod", "pod": "nginx-1", "container": "nginx"})
+	b := labelKey(map[string]string{"namespace": "prod", "pod": "nginx-1", "container": "nginx"})
+	c := labelKey(map[string]string{"namespace": "prod", "pod": "nginx-2", "container": "nginx"})
+
+	if a != b {
+		t.Errorf("labelKey should be identical for identical label sets")
+	}
+	if a == c {
+		t.Errorf("labelKey should differ when pod differs")
+	}
+}
+
+func TestFormatEvent(t *testing.T) {
+	evt := EventInfo{NamespaceName: "prod", PodName: "nginx-1", ContainerName: "nginx", Operation: "File", Resource: "/etc/passwd", Result: "Passed"}
+
+	jsonOut, err := formatEvent(evt, true)
+	if err != nil {
+		t.Fatalf("formatEvent(json) error: %s", err)
+	}
+	if len(jsonOut) == 0 || jsonOut[0] != '{' {
+		t.Errorf("formatEvent(json) = %q, want a JSON object", jsonOut)
+	}
+
+	textOut, err := formatEvent(evt, false)
+	if err != nil {
+		t.Fatalf("formatEvent(text) error: %s", err)
+	}
+	want := "prod/nginx-1 (nginx) File /etc/passwd Passed"
+	if !strings.HasSuffix(string(textOut), want) {
+		t.Errorf("formatEvent(text) = %q, want it to end with %q", textOut, want)
+	}
+}
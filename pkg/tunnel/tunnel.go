@@ -0,0 +1,178 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2021 Authors of KubeArmor
+
+// Package tunnel provides a reusable way to reach the KubeArmor relay from
+// the client, regardless of whether the client is running inside or
+// outside the cluster.
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/kubearmor/kubearmor-client/k8s"
+	"github.com/kubearmor/kubearmor-client/utils"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Backend identifies which transport a Tunnel ended up using
+type Backend int
+
+// Supported tunnel backends
+const (
+	// BackendEndpoint is an explicit --endpoint passthrough
+	BackendEndpoint Backend = iota
+	// BackendService dials the relay's in-cluster Service DNS name directly
+	BackendService
+	// BackendPortForward uses a SPDY pod port-forward
+	BackendPortForward
+)
+
+// Options configures how a Tunnel to the relay is established
+type Options struct {
+	// Endpoint, if set, is used verbatim as the gRPC target and skips all
+	// auto-detection below.
+	Endpoint string
+
+	// MatchLabels selects the relay pod for the port-forward backend.
+	MatchLabels map[string]string
+	// ServiceName/ServicePort/Namespace address the relay's ClusterIP
+	// Service when running in-cluster.
+	ServiceName string
+	ServicePort int64
+	Namespace   string
+
+	LocalPort  int64
+	RemotePort int64
+
+	// KubeconfigContext, if set, selects a non-default context when
+	// building the Kubernetes client used to locate the relay pod.
+	KubeconfigContext string
+}
+
+// Tunnel is an established (or passed-through) path to the KubeArmor relay
+type Tunnel struct {
+	// Address is the "host:port" gRPC target to dial
+	Address string
+	Backend Backend
+
+	fp *utils.ForwardedPort
+}
+
+// Close releases any resources (e.g. the port-forward) held by the tunnel.
+// It is a no-op for the Service and Endpoint backends.
+func (t *Tunnel) Close() error {
+	if t.fp == nil {
+		return nil
+	}
+	return t.fp.Close()
+}
+
+// OnError registers a callback invoked whenever the underlying transport
+// fails (e.g. the relay pod is rescheduled and the port-forward drops).
+// It is a no-op for the Service and Endpoint backends, which don't carry
+// a long-lived local process to watch.
+func (t *Tunnel) OnError(cb func(error)) {
+	if t.fp == nil {
+		return
+	}
+	t.fp.OnError(cb)
+}
+
+// New picks the best available backend and returns a Tunnel to the relay.
+//
+// Preference order: an explicit --endpoint always wins; otherwise, if the
+// client is running inside the cluster (detected via rest.InClusterConfig),
+// the relay's Service DNS name is dialed directly; if that is not
+// reachable, or the client is running outside the cluster, it falls back
+// to the existing SPDY pod port-forward.
+func New(c *k8s.Client, o Options) (*Tunnel, error) {
+	if o.Endpoint != "" {
+		return &Tunnel{Address: o.Endpoint, Backend: BackendEndpoint}, nil
+	}
+
+	if o.KubeconfigContext != "" {
+		ctxClient, err := clientForContext(o.KubeconfigContext)
+		if err != nil {
+			return nil, fmt.Errorf("unable to build client for kubeconfig context %q: %w", o.KubeconfigContext, err)
+		}
+		c = ctxClient
+	}
+
+	if runningInCluster() {
+		addr := serviceAddress(o)
+		if dialable(addr, time.Second) {
+			return &Tunnel{Address: addr, Backend: BackendService}, nil
+		}
+	}
+
+	fp, err := utils.StartManagedForward(c, o.Namespace, o.MatchLabels, o.LocalPort, o.RemotePort)
+	if err != nil {
+		return nil, err
+	}
+	return &Tunnel{
+		Address: "localhost:" + strconv.FormatInt(fp.LocalPort, 10),
+		Backend: BackendPortForward,
+		fp:      fp,
+	}, nil
+}
+
+// clientForContext rebuilds a *k8s.Client against the named kubeconfig
+// context, so the relay pod/service below is resolved in the cluster the
+// caller actually asked for via --context rather than whatever context the
+// original client happened to be built with.
+func clientForContext(contextName string) (*k8s.Client, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: contextName}
+
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubeconfig context %q: %w", contextName, err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build clientset for context %q: %w", contextName, err)
+	}
+
+	return &k8s.Client{Config: cfg, K8sClientset: clientset}, nil
+}
+
+// runningInCluster reports whether the client is executing inside a
+// Kubernetes pod, i.e. whether an in-cluster kubeconfig is available.
+func runningInCluster() bool {
+	_, err := rest.InClusterConfig()
+	return err == nil
+}
+
+// serviceAddress builds the relay's in-cluster Service DNS address, e.g.
+// "kubearmor-relay.kube-system.svc.cluster.local:32767".
+func serviceAddress(o Options) string {
+	ns := o.Namespace
+	if ns == "" {
+		ns = "kube-system"
+	}
+	name := o.ServiceName
+	if name == "" {
+		name = "kubearmor-relay"
+	}
+	port := o.ServicePort
+	if port == 0 {
+		port = o.RemotePort
+	}
+	return fmt.Sprintf("%s.%s.svc.cluster.local:%d", name, ns, port)
+}
+
+// dialable reports whether addr accepts a TCP connection within timeout.
+func dialable(addr string, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
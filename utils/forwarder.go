@@ -0,0 +1,305 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kubearmor/kubearmor-client/k8s"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// backoff bounds for re-establishing a managed forward after the target
+// pod disappears or the SPDY stream errors out.
+const (
+	forwardBackoffMin = 500 * time.Millisecond
+	forwardBackoffMax = 30 * time.Second
+)
+
+// ForwardedPort is a long-lived, self-healing port-forward. Unlike a plain
+// PortForwardOpt, it survives the target pod restarting or being
+// rescheduled: a supervisor goroutine watches the pod and re-resolves a
+// fresh one from MatchLabels whenever the current one goes away.
+type ForwardedPort struct {
+	LocalPort  int64
+	RemotePort int64
+
+	readyCh   chan struct{}
+	readyOnce sync.Once
+	doneCh    chan struct{}
+	doneOnce  sync.Once
+
+	mu      sync.Mutex
+	err     error
+	onError func(error)
+	stop    chan struct{}
+}
+
+// Ready is closed once the first forward successfully comes up.
+func (fp *ForwardedPort) Ready() <-chan struct{} {
+	return fp.readyCh
+}
+
+// Done is closed once the forward has been stopped for good: Close was
+// called, or the first forward failed before it ever came up. It is not
+// closed on a later transient failure that the supervisor is about to retry.
+func (fp *ForwardedPort) Done() <-chan struct{} {
+	return fp.doneCh
+}
+
+// Err returns the most recent forwarding error, if any.
+func (fp *ForwardedPort) Err() error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return fp.err
+}
+
+// OnError registers a callback invoked every time the forward fails,
+// before the supervisor attempts to reconnect. This lets callers (e.g. a
+// gRPC client loop) react to a broken stream instead of hanging silently.
+func (fp *ForwardedPort) OnError(cb func(error)) {
+	fp.mu.Lock()
+	fp.onError = cb
+	fp.mu.Unlock()
+}
+
+func (fp *ForwardedPort) markReady() {
+	fp.readyOnce.Do(func() { close(fp.readyCh) })
+}
+
+func (fp *ForwardedPort) reportError(err error) {
+	fp.mu.Lock()
+	fp.err = err
+	cb := fp.onError
+	fp.mu.Unlock()
+	if cb != nil {
+		cb(err)
+	}
+}
+
+func (fp *ForwardedPort) markDone() {
+	fp.doneOnce.Do(func() { close(fp.doneCh) })
+}
+
+// StartManagedForward establishes a self-healing port-forward to a pod
+// matching matchLabels in namespace. It returns as soon as the first
+// forward is up; subsequent pod loss/stream errors are retried in the
+// background with capped exponential backoff, and surfaced via OnError.
+func StartManagedForward(c *k8s.Client, namespace string, matchLabels map[string]string, localPort, remotePort int64) (*ForwardedPort, error) {
+	fp := &ForwardedPort{
+		LocalPort:  localPort,
+		RemotePort: remotePort,
+		readyCh:    make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+
+	stop := make(chan struct{})
+	go fp.supervise(c, namespace, matchLabels, stop)
+
+	fp.mu.Lock()
+	fp.stop = stop
+	fp.mu.Unlock()
+
+	select {
+	case <-fp.readyCh:
+		return fp, nil
+	case <-fp.doneCh:
+		return nil, fp.Err()
+	}
+}
+
+// Close signals the supervisor goroutine to exit and stop retrying.
+func (fp *ForwardedPort) Close() error {
+	fp.mu.Lock()
+	stop := fp.stop
+	fp.mu.Unlock()
+	if stop != nil {
+		select {
+		case <-stop:
+		default:
+			close(stop)
+		}
+	}
+	fp.markDone()
+	return nil
+}
+
+func (fp *ForwardedPort) supervise(c *k8s.Client, namespace string, matchLabels map[string]string, stop chan struct{}) {
+	backoff := forwardBackoffMin
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		// A failure before the first forward ever came up ends supervision,
+		// so StartManagedForward returns that error instead of blocking.
+		select {
+		case <-fp.readyCh:
+		default:
+			if fp.Err() != nil {
+				fp.markDone()
+				return
+			}
+		}
+
+		pf := &PortForwardOpt{
+			LocalPort:   fp.LocalPort,
+			RemotePort:  fp.RemotePort,
+			Namespace:   namespace,
+			MatchLabels: matchLabels,
+		}
+
+		if err := pf.getPodName(c); err != nil {
+			fp.reportError(fmt.Errorf("unable to resolve kubearmor pod: %w", err))
+			if !sleepOrStop(stop, backoff) {
+				return
+			}
+			backoff = nextBackoff(backoff)
+			continue
+		}
+
+		lp, err := pf.getLocalPort()
+		if err != nil {
+			fp.reportError(err)
+			if !sleepOrStop(stop, backoff) {
+				return
+			}
+			backoff = nextBackoff(backoff)
+			continue
+		}
+		pf.LocalPort = lp
+		fp.LocalPort = lp
+
+		streamErrCh, err := k8sPortForward(c, pf)
+		if err != nil {
+			fp.reportError(err)
+			if !sleepOrStop(stop, backoff) {
+				return
+			}
+			backoff = nextBackoff(backoff)
+			continue
+		}
+
+		// forward is up: reset backoff and tell the caller.
+		backoff = forwardBackoffMin
+		fp.markReady()
+
+		podGone := make(chan struct{})
+		watchStop := make(chan struct{})
+		go watchPodHealth(c, pf.Namespace, pf.PodName, podGone, watchStop)
+
+		select {
+		case err := <-streamErrCh:
+			close(watchStop)
+			if closeErr := pf.Close(); closeErr != nil {
+				fmt.Fprintf(os.Stderr, "error tearing down port-forward after stream error: %s\n", closeErr)
+			}
+			if err != nil {
+				fp.reportError(fmt.Errorf("port-forward stream closed: %w", err))
+			}
+		case <-podGone:
+			close(watchStop)
+			if closeErr := pf.Close(); closeErr != nil {
+				fmt.Fprintf(os.Stderr, "error tearing down stale port-forward: %s\n", closeErr)
+			}
+			fp.reportError(fmt.Errorf("kubearmor pod %s/%s is no longer available", pf.Namespace, pf.PodName))
+		case <-stop:
+			if closeErr := pf.Close(); closeErr != nil {
+				fmt.Fprintf(os.Stderr, "error tearing down port-forward: %s\n", closeErr)
+			}
+			close(watchStop)
+			return
+		}
+
+		if !sleepOrStop(stop, backoff) {
+			return
+		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+func sleepOrStop(stop chan struct{}, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func nextBackoff(cur time.Duration) time.Duration {
+	next := cur * 2
+	if next > forwardBackoffMax {
+		return forwardBackoffMax
+	}
+	return next
+}
+
+// watchPodHealth watches a single pod by name and signals podGone once it
+// is deleted or transitions to NotReady. It stops watching when stop is
+// closed.
+func watchPodHealth(c *k8s.Client, namespace, podName string, podGone chan<- struct{}, stop <-chan struct{}) {
+	selector := fields.OneTermEqualSelector("metadata.name", podName)
+
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector.String()
+			return c.K8sClientset.CoreV1().Pods(namespace).List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector.String()
+			return c.K8sClientset.CoreV1().Pods(namespace).Watch(context.Background(), options)
+		},
+	}
+
+	_, informer := cache.NewInformer(lw, &corev1.Pod{}, 0, cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, newObj interface{}) {
+			pod, ok := newObj.(*corev1.Pod)
+			if !ok {
+				return
+			}
+			if !podIsReady(pod) {
+				notify(podGone)
+			}
+		},
+		DeleteFunc: func(interface{}) {
+			notify(podGone)
+		},
+	})
+
+	informer.Run(stop)
+}
+
+func podIsReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// notify performs a non-blocking send so a pod watch that fires multiple
+// update/delete events doesn't block on a channel the supervisor has
+// already stopped reading from.
+func notify(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
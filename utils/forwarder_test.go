@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNextBackoff(t *testing.T) {
+	cases := []struct {
+		cur  time.Duration
+		want time.Duration
+	}{
+		{forwardBackoffMin, 2 * forwardBackoffMin},
+		{forwardBackoffMax, forwardBackoffMax},
+		{forwardBackoffMax / 2, forwardBackoffMax},
+	}
+
+	for _, c := range cases {
+		if got := nextBackoff(c.cur); got != c.want {
+			t.Errorf("nextBackoff(%s) = %s, want %s", c.cur, got, c.want)
+		}
+	}
+}
+
+func TestPodIsReady(t *testing.T) {
+	readyPod := &corev1.Pod{
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+	if !podIsReady(readyPod) {
+		t.Errorf("expected pod with PodReady=True to be ready")
+	}
+
+	notReadyPod := &corev1.Pod{
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodReady, Status: corev1.ConditionFalse},
+			},
+		},
+	}
+	if podIsReady(notReadyPod) {
+		t.Errorf("expected pod with PodReady=False to not be ready")
+	}
+
+	now := metav1.Now()
+	deletedPod := readyPod.DeepCopy()
+	deletedPod.DeletionTimestamp = &now
+	if podIsReady(deletedPod) {
+		t.Errorf("expected pod with a DeletionTimestamp to not be ready")
+	}
+
+	noConditionsPod := &corev1.Pod{}
+	if podIsReady(noConditionsPod) {
+		t.Errorf("expected pod with no PodReady condition to not be ready")
+	}
+}
+
+func TestNotifyDoesNotBlockOnFullChannel(t *testing.T) {
+	ch := make(chan struct{}, 1)
+	notify(ch)
+	notify(ch) // would block without the non-blocking select
+	<-ch
+}
@@ -12,7 +12,7 @@ import (
 	"net/url"
 	"os"
 	"strconv"
-	"strings"
+	"sync"
 
 	"github.com/kubearmor/kubearmor-client/k8s"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -20,6 +20,20 @@ import (
 	"k8s.io/client-go/transport/spdy"
 )
 
+// Local ports are picked from the IANA ephemeral range. allocatedPorts
+// tracks ports this process has already handed out so that repeated calls
+// (e.g. one per reconnect) don't collide with each other, not just with
+// whatever else happens to be listening on the machine.
+const (
+	ephemeralPortStart int64 = 49152
+	ephemeralPortEnd   int64 = 65535
+)
+
+var (
+	allocatedPortsMu sync.Mutex
+	allocatedPorts   = map[int64]bool{}
+)
+
 // PortForwardOpt details for a pod
 type PortForwardOpt struct {
 	LocalPort   int64
@@ -27,6 +41,20 @@ type PortForwardOpt struct {
 	MatchLabels map[string]string
 	Namespace   string
 	PodName     string
+
+	stopChan chan struct{}
+}
+
+// Close tears down the port-forward, if one was started, and frees its
+// local port for reuse.
+func (pf *PortForwardOpt) Close() error {
+	releaseLocalPort(pf.LocalPort)
+	if pf.stopChan == nil {
+		return nil
+	}
+	close(pf.stopChan)
+	pf.stopChan = nil
+	return nil
 }
 
 // InitiatePortForward : Initiate port forwarding
@@ -59,7 +87,7 @@ func (pf *PortForwardOpt) handlePortForward(c *k8s.Client) error {
 	}
 	pf.LocalPort = lp
 
-	err = k8sPortForward(c, *pf)
+	_, err = k8sPortForward(c, pf)
 	if err != nil {
 		return fmt.Errorf("\ncould not do kubearmor portforward, error=%s", err.Error())
 	}
@@ -67,26 +95,34 @@ func (pf *PortForwardOpt) handlePortForward(c *k8s.Client) error {
 
 }
 
-// k8s port forward
-func k8sPortForward(c *k8s.Client, pf PortForwardOpt) error {
+// k8sPortForward sets up the SPDY port-forward and blocks until it is
+// either ready or fails to start. On success it returns a channel that
+// later receives the forwarder's terminal error (nil on a clean Close) -
+// callers that care about the stream dying after startup, not just at
+// startup, should keep watching it.
+func k8sPortForward(c *k8s.Client, pf *PortForwardOpt) (<-chan error, error) {
 	roundTripper, upgrader, err := spdy.RoundTripperFor(c.Config)
 	if err != nil {
-		return fmt.Errorf("\nunable to create round tripper and upgrader, error=%s", err.Error())
+		return nil, fmt.Errorf("\nunable to create round tripper and upgrader, error=%s", err.Error())
 	}
 
 	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", pf.Namespace, pf.PodName)
-	hostIP := strings.TrimLeft(c.Config.Host, "https:/")
-	serverURL := url.URL{Scheme: "https", Path: path, Host: hostIP}
+	hostURL, err := url.Parse(c.Config.Host)
+	if err != nil {
+		return nil, fmt.Errorf("\nunable to parse api server host %q, error=%s", c.Config.Host, err.Error())
+	}
+	serverURL := url.URL{Scheme: "https", Path: path, Host: hostURL.Host}
 
 	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: roundTripper}, http.MethodPost, &serverURL)
 
-	StopChan, readyChan := make(chan struct{}, 1), make(chan struct{}, 1)
+	pf.stopChan = make(chan struct{}, 1)
+	readyChan := make(chan struct{}, 1)
 	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
 
 	forwarder, err := portforward.New(dialer, []string{fmt.Sprintf("%d:%d", pf.LocalPort, pf.RemotePort)},
-		StopChan, readyChan, out, errOut)
+		pf.stopChan, readyChan, out, errOut)
 	if err != nil {
-		return fmt.Errorf("\nunable to portforward. error=%s", err.Error())
+		return nil, fmt.Errorf("\nunable to portforward. error=%s", err.Error())
 	}
 
 	errChan := make(chan error, 1)
@@ -96,11 +132,10 @@ func k8sPortForward(c *k8s.Client, pf PortForwardOpt) error {
 
 	select {
 	case err = <-errChan:
-		close(errChan)
 		forwarder.Close()
-		return fmt.Errorf("could not create port forward %s", err)
+		return nil, fmt.Errorf("could not create port forward %s", err)
 	case <-readyChan:
-		return nil
+		return errChan, nil
 	}
 }
 
@@ -125,33 +160,73 @@ func (pf *PortForwardOpt) getPodName(c *k8s.Client) error {
 	return nil
 }
 
-// Returns the local port for the port forwarder
+// Returns the local port for the port forwarder, preferring pf.LocalPort
+// and otherwise scanning the ephemeral range. Ports handed out by this
+// process are remembered so concurrent/reconnecting forwards don't race
+// each other onto the same port.
 func (pf *PortForwardOpt) getLocalPort() (int64, error) {
-	port := pf.LocalPort
-
-	for {
-		listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.FormatInt(port, 10))
-		if err == nil {
-			if err := listener.Close(); err != nil {
-				return -1, err
-			}
+	if port, ok := tryReservePort(pf.LocalPort); ok {
+		fmt.Fprintf(os.Stderr, "local port to be used for port forwarding %s: %d \n", pf.PodName, port)
+		return port, nil
+	}
+
+	for attempt := int64(0); attempt < ephemeralPortEnd-ephemeralPortStart; attempt++ {
+		candidate, err := randomEphemeralPort()
+		if err != nil {
+			return -1, err
+		}
+		if port, ok := tryReservePort(candidate); ok {
 			fmt.Fprintf(os.Stderr, "local port to be used for port forwarding %s: %d \n", pf.PodName, port)
 			return port, nil
 		}
+	}
+	return -1, errors.New("unable to find a free local port in the ephemeral range")
+}
 
-		n, err := getRandomInt()
-		if err != nil {
-			return n, err
-		}
-		port = n + 32768
+// releaseLocalPort frees a port reserved by getLocalPort so it can be
+// reused by a later port-forward in this process (e.g. after a reconnect).
+func releaseLocalPort(port int64) {
+	allocatedPortsMu.Lock()
+	delete(allocatedPorts, port)
+	allocatedPortsMu.Unlock()
+}
+
+// tryReservePort reserves port if it is both free on the loopback
+// interface and not already handed out to another forward in this
+// process.
+func tryReservePort(port int64) (int64, bool) {
+	allocatedPortsMu.Lock()
+	if allocatedPorts[port] {
+		allocatedPortsMu.Unlock()
+		return -1, false
+	}
+	allocatedPortsMu.Unlock()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.FormatInt(port, 10))
+	if err != nil {
+		return -1, false
+	}
+	if err := listener.Close(); err != nil {
+		return -1, false
+	}
+
+	allocatedPortsMu.Lock()
+	if allocatedPorts[port] {
+		// lost a race with another goroutine between the check above and
+		// the listen/close probe
+		allocatedPortsMu.Unlock()
+		return -1, false
 	}
+	allocatedPorts[port] = true
+	allocatedPortsMu.Unlock()
+	return port, true
 }
 
-// get random integer
-func getRandomInt() (int64, error) {
-	n, err := rand.Int(rand.Reader, big.NewInt(32900-32768))
+// randomEphemeralPort returns a random port within the ephemeral range.
+func randomEphemeralPort() (int64, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(ephemeralPortEnd-ephemeralPortStart))
 	if err != nil {
 		return -1, errors.New("unable to generate random integer for port")
 	}
-	return n.Int64(), nil
+	return n.Int64() + ephemeralPortStart, nil
 }
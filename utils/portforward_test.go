@@ -0,0 +1,39 @@
+package utils
+
+import "testing"
+
+func TestTryReservePortCollision(t *testing.T) {
+	candidate, err := randomEphemeralPort()
+	if err != nil {
+		t.Fatalf("randomEphemeralPort returned error: %s", err)
+	}
+
+	port, ok := tryReservePort(candidate)
+	if !ok {
+		t.Fatalf("expected to reserve free ephemeral port %d, got ok=false", candidate)
+	}
+	defer releaseLocalPort(port)
+
+	if _, ok := tryReservePort(port); ok {
+		t.Fatalf("expected a second reservation of the same port %d to fail", port)
+	}
+
+	releaseLocalPort(port)
+
+	if _, ok := tryReservePort(port); !ok {
+		t.Fatalf("expected port %d to be reservable again after release", port)
+	}
+	releaseLocalPort(port)
+}
+
+func TestRandomEphemeralPort(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		port, err := randomEphemeralPort()
+		if err != nil {
+			t.Fatalf("randomEphemeralPort returned error: %s", err)
+		}
+		if port < ephemeralPortStart || port >= ephemeralPortEnd {
+			t.Fatalf("port %d outside ephemeral range [%d, %d)", port, ephemeralPortStart, ephemeralPortEnd)
+		}
+	}
+}